@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/anik-ghosh-au7/cloudflare-tunnel/access"
+	"github.com/anik-ghosh-au7/cloudflare-tunnel/cliutil"
+)
+
+// dispatchAccess routes `cloudflare-tunnel access <subcommand>` invocations.
+func dispatchAccess(args []string) {
+	if len(args) == 0 {
+		fatal(cliutil.NewUsageError("access: expected a subcommand (ssh)"))
+	}
+	switch args[0] {
+	case "ssh":
+		runAccessSSH(args[1:])
+	default:
+		fatal(cliutil.NewUsageError("access: unknown subcommand %q", args[0]))
+	}
+}
+
+// runAccessSSH implements `cloudflare-tunnel access ssh --hostname foo.example.com`:
+// an SSH ProxyCommand-compatible stdio<->wss bridge to an Access-protected
+// route, so `ssh -o ProxyCommand="cloudflare-tunnel access ssh --hostname %h" user@foo.example.com`
+// works without running a local SOCKS5 proxy.
+func runAccessSSH(args []string) {
+	fs := flag.NewFlagSet("access ssh", flag.ExitOnError)
+	hostname := fs.String("hostname", "", "Access-protected hostname to connect to (e.g. ssh.example.com)")
+	fs.Parse(args)
+
+	if *hostname == "" {
+		fatal(cliutil.NewUsageError("access ssh: -hostname is required"))
+	}
+
+	ctx := context.Background()
+	token, err := access.EnsureToken(ctx, *hostname)
+	if err != nil {
+		fatal(fmt.Errorf("failed to obtain Access token for %s: %w", *hostname, err))
+	}
+
+	conn, err := access.DialWebsocket(ctx, *hostname, token)
+	if err != nil {
+		fatal(fmt.Errorf("failed to connect to %s: %w", *hostname, err))
+	}
+	defer conn.Close()
+
+	if err := access.Bridge(conn, os.Stdin, os.Stdout); err != nil {
+		logger.Debug().Err(err).Str("hostname", *hostname).Msg("access ssh bridge closed")
+	}
+}
@@ -0,0 +1,66 @@
+package access
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/websocket"
+)
+
+// DialWebsocket opens the wss carrier connection to an Access-protected
+// tunnel hostname, injecting the Access JWT as Cf-Access-Token so the
+// edge's Access policy check passes before the stream reaches the origin.
+func DialWebsocket(ctx context.Context, hostname, token string) (*websocket.Conn, error) {
+	u := url.URL{Scheme: "wss", Host: hostname, Path: "/"}
+	header := http.Header{}
+	header.Set("Cf-Access-Token", token)
+
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("failed to dial Access carrier (status %d): %w", resp.StatusCode, err)
+		}
+		return nil, fmt.Errorf("failed to dial Access carrier: %w", err)
+	}
+	return conn, nil
+}
+
+// Bridge pumps bytes between stdin/stdout and the websocket carrier until
+// either side closes, implementing the stdio<->wss bridge an SSH
+// ProxyCommand needs.
+func Bridge(conn *websocket.Conn, stdin io.Reader, stdout io.Writer) error {
+	errc := make(chan error, 2)
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				errc <- err
+				return
+			}
+			if _, err := stdout.Write(data); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := stdin.Read(buf)
+			if n > 0 {
+				if werr := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); werr != nil {
+					errc <- werr
+					return
+				}
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	return <-errc
+}
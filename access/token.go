@@ -0,0 +1,213 @@
+// Package access implements the client side of Cloudflare-Access-protected
+// TCP/SSH routes: a browser-based device auth flow that exchanges a login
+// for a JWT, a local cache of that JWT under ~/.cloudflared, and a websocket
+// carrier that injects it as Cf-Access-Token on the tunnel connection.
+//
+// The login flow mirrors `cloudflared access login`'s CLI transfer dance:
+// Access never hands the JWT back on the localhost redirect itself, only a
+// one-time transfer token, which must then be redeemed against the
+// cli_transfer endpoint for the real JWT (see redeemTransferToken).
+package access
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// loginTimeout bounds how long EnsureToken waits for the user to complete
+// the browser login before giving up.
+const loginTimeout = 2 * time.Minute
+
+// tokenDir is where cached Access JWTs are stored, one file per hostname,
+// mirroring cloudflared's own ~/.cloudflared layout.
+func tokenDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve home directory: %w", err)
+	}
+	return filepath.Join(home, ".cloudflared"), nil
+}
+
+func tokenPath(hostname string) (string, error) {
+	dir, err := tokenDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, hostname+".tok"), nil
+}
+
+// EnsureToken returns a cached, still-valid Access JWT for hostname, or runs
+// the browser-based device auth flow to obtain and cache a fresh one.
+func EnsureToken(ctx context.Context, hostname string) (string, error) {
+	if tok, ok := cachedToken(hostname); ok {
+		return tok, nil
+	}
+	tok, err := login(ctx, hostname)
+	if err != nil {
+		return "", err
+	}
+	if err := cacheToken(hostname, tok); err != nil {
+		return "", fmt.Errorf("failed to cache Access token: %w", err)
+	}
+	return tok, nil
+}
+
+func cachedToken(hostname string) (string, bool) {
+	path, err := tokenPath(hostname)
+	if err != nil {
+		return "", false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	tok := strings.TrimSpace(string(data))
+	if tok == "" || jwtExpired(tok) {
+		return "", false
+	}
+	return tok, true
+}
+
+func cacheToken(hostname, token string) error {
+	dir, err := tokenDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	path, err := tokenPath(hostname)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(token), 0600)
+}
+
+// jwtExpired reports whether a JWT's exp claim has passed. It does not
+// verify the signature: expiry here is only a cache-freshness check, since
+// the origin's Access policy check is the actual trust boundary.
+func jwtExpired(token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return true
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return true
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return true
+	}
+	return time.Now().Unix() >= claims.Exp
+}
+
+// login runs Cloudflare Access's CLI login flow: it starts a local callback
+// listener, opens the hostname's Access login page pointed at that
+// listener, and waits for the one-time transfer token the callback
+// receives. That token is not itself a usable JWT -- it's redeemed for the
+// real Cf-Access-Token JWT via redeemTransferToken.
+func login(ctx context.Context, hostname string) (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("failed to start local callback listener: %w", err)
+	}
+	defer listener.Close()
+
+	transferCh := make(chan string, 1)
+	errCh := make(chan error, 1)
+	srv := &http.Server{Handler: callbackHandler(transferCh, errCh)}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	callbackURL := fmt.Sprintf("http://%s/callback", listener.Addr())
+	loginURL := fmt.Sprintf("https://%s/cdn-cgi/access/cli?redirect_url=%s", hostname, url.QueryEscape(callbackURL))
+	if err := openBrowser(loginURL); err != nil {
+		fmt.Fprintf(os.Stderr, "Open the following URL in a browser to authenticate:\n%s\n", loginURL)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, loginTimeout)
+	defer cancel()
+	var transferToken string
+	select {
+	case transferToken = <-transferCh:
+	case err := <-errCh:
+		return "", err
+	case <-ctx.Done():
+		return "", fmt.Errorf("timed out waiting for Access login: %w", ctx.Err())
+	}
+
+	return redeemTransferToken(ctx, hostname, transferToken)
+}
+
+func callbackHandler(transferCh chan<- string, errCh chan<- error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tok := r.URL.Query().Get("token")
+		if tok == "" {
+			errCh <- fmt.Errorf("access login callback missing transfer token")
+			http.Error(w, "missing token", http.StatusBadRequest)
+			return
+		}
+		transferCh <- tok
+		fmt.Fprintln(w, "Authenticated, you may close this window.")
+	})
+}
+
+// redeemTransferToken exchanges the one-time transfer token the browser
+// callback received for the real Access JWT, with a single GET against the
+// same cli_transfer endpoint `cloudflared access login` redeems it against.
+// It does not poll or retry: any non-200 response is returned as an error.
+func redeemTransferToken(ctx context.Context, hostname, transferToken string) (string, error) {
+	transferURL := fmt.Sprintf("https://%s/cdn-cgi/access/cli_transfer?kid=%s", hostname, url.QueryEscape(transferToken))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, transferURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to build Access transfer request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach Access transfer endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("access transfer endpoint returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read Access transfer response: %w", err)
+	}
+	tok := strings.TrimSpace(string(body))
+	if tok == "" {
+		return "", fmt.Errorf("access transfer endpoint returned an empty token")
+	}
+	return tok, nil
+}
+
+// openBrowser launches the platform's default browser at url, the same way
+// `cloudflared access login` surfaces its auth page.
+func openBrowser(url string) error {
+	var cmd string
+	var args []string
+	switch runtime.GOOS {
+	case "darwin":
+		cmd, args = "open", []string{url}
+	case "windows":
+		cmd, args = "rundll32", []string{"url.dll,FileProtocolHandler", url}
+	default:
+		cmd, args = "xdg-open", []string{url}
+	}
+	return exec.Command(cmd, args...).Start()
+}
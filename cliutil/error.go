@@ -0,0 +1,21 @@
+// Package cliutil holds small helpers shared by the CLI's flag handling and
+// input validation.
+package cliutil
+
+import "fmt"
+
+// UsageError indicates the user invoked the CLI incorrectly (bad flags,
+// malformed input) rather than a runtime failure. Callers should print the
+// message alone and exit non-zero instead of dumping a stack trace.
+type UsageError struct {
+	msg string
+}
+
+// NewUsageError builds a UsageError with a formatted message.
+func NewUsageError(format string, args ...interface{}) *UsageError {
+	return &UsageError{msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *UsageError) Error() string {
+	return e.msg
+}
@@ -0,0 +1,175 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// CloudflareProvider manages CNAME records for tunnel hostnames in a single
+// Cloudflare zone.
+type CloudflareProvider struct {
+	ZoneID   string
+	APIToken string
+	Proxied  bool
+	TTL      int // 1 means "automatic", matching Cloudflare's own API.
+
+	httpClient *http.Client
+}
+
+// NewCloudflareProvider builds a Provider for the given zone. proxied
+// controls whether created/updated records are proxied through Cloudflare;
+// ttl is the record TTL in seconds, or 1 for automatic.
+func NewCloudflareProvider(zoneID, apiToken string, proxied bool, ttl int) *CloudflareProvider {
+	if ttl <= 0 {
+		ttl = 1
+	}
+	return &CloudflareProvider{ZoneID: zoneID, APIToken: apiToken, Proxied: proxied, TTL: ttl, httpClient: &http.Client{}}
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	Proxied bool   `json:"proxied"`
+	TTL     int    `json:"ttl"`
+}
+
+// Ensure creates hostname's CNAME if it doesn't exist, or PUTs an update if
+// its content differs from target.
+func (p *CloudflareProvider) Ensure(ctx context.Context, hostname, target string) error {
+	existing, err := p.find(ctx, hostname)
+	if err != nil {
+		return err
+	}
+	record := cloudflareRecord{Type: "CNAME", Name: hostname, Content: target, Proxied: p.Proxied, TTL: p.TTL}
+	if existing == nil {
+		_, err := p.do(ctx, http.MethodPost, "/dns_records", record)
+		return err
+	}
+	if existing.Content == target {
+		return nil
+	}
+	_, err = p.do(ctx, http.MethodPut, "/dns_records/"+existing.ID, record)
+	return err
+}
+
+// EnsureAll reconciles several hostname->target pairs in a single pass,
+// using one List call to resolve every existing record instead of one
+// lookup per hostname. This is the bulk path protocol=all uses, where both
+// the service route and the SOCKS5 proxy route need a record.
+func (p *CloudflareProvider) EnsureAll(ctx context.Context, targets map[string]string) error {
+	records, err := p.List(ctx)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]Record, len(records))
+	for _, r := range records {
+		if r.Type == "CNAME" {
+			byName[r.Name] = r
+		}
+	}
+
+	for hostname, target := range targets {
+		record := cloudflareRecord{Type: "CNAME", Name: hostname, Content: target, Proxied: p.Proxied, TTL: p.TTL}
+		existing, ok := byName[hostname]
+		if !ok {
+			if _, err := p.do(ctx, http.MethodPost, "/dns_records", record); err != nil {
+				return fmt.Errorf("hostname %q: %w", hostname, err)
+			}
+			continue
+		}
+		if existing.Content == target {
+			continue
+		}
+		if _, err := p.do(ctx, http.MethodPut, "/dns_records/"+existing.ID, record); err != nil {
+			return fmt.Errorf("hostname %q: %w", hostname, err)
+		}
+	}
+	return nil
+}
+
+// Delete removes hostname's record, if one exists.
+func (p *CloudflareProvider) Delete(ctx context.Context, hostname string) error {
+	existing, err := p.find(ctx, hostname)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return nil
+	}
+	_, err = p.do(ctx, http.MethodDelete, "/dns_records/"+existing.ID, nil)
+	return err
+}
+
+// List returns every DNS record in the zone.
+func (p *CloudflareProvider) List(ctx context.Context) ([]Record, error) {
+	body, err := p.do(ctx, http.MethodGet, "/dns_records", nil)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Result []cloudflareRecord `json:"result"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse DNS list response: %w", err)
+	}
+	records := make([]Record, len(result.Result))
+	for i, r := range result.Result {
+		records[i] = Record{ID: r.ID, Type: r.Type, Name: r.Name, Content: r.Content, Proxied: r.Proxied, TTL: r.TTL}
+	}
+	return records, nil
+}
+
+func (p *CloudflareProvider) find(ctx context.Context, hostname string) (*cloudflareRecord, error) {
+	body, err := p.do(ctx, http.MethodGet, "/dns_records?name="+url.QueryEscape(hostname), nil)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Result []cloudflareRecord `json:"result"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse DNS lookup response: %w", err)
+	}
+	if len(result.Result) == 0 {
+		return nil, nil
+	}
+	return &result.Result[0], nil
+}
+
+func (p *CloudflareProvider) do(ctx context.Context, method, path string, payload interface{}) ([]byte, error) {
+	var buf io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal DNS request: %w", err)
+		}
+		buf = bytes.NewReader(data)
+	}
+
+	reqURL := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s%s", p.ZoneID, path)
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build DNS API request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+p.APIToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("DNS API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 400 {
+		return nil, parseAPIError(resp, body)
+	}
+	return body, nil
+}
@@ -0,0 +1,42 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// APIError is a parsed Cloudflare API error envelope, surfaced with its ray
+// ID and error code instead of a raw response body dump.
+type APIError struct {
+	StatusCode int
+	RayID      string
+	Code       int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("cloudflare DNS API error (status %d, code %d, ray %s): %s",
+		e.StatusCode, e.Code, e.RayID, e.Message)
+}
+
+// parseAPIError builds an APIError from a failed response, pulling the ray
+// ID from the Cf-Ray header and the first error out of the JSON envelope.
+func parseAPIError(resp *http.Response, body []byte) error {
+	var envelope struct {
+		Errors []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	_ = json.Unmarshal(body, &envelope)
+
+	apiErr := &APIError{StatusCode: resp.StatusCode, RayID: resp.Header.Get("Cf-Ray")}
+	if len(envelope.Errors) > 0 {
+		apiErr.Code = envelope.Errors[0].Code
+		apiErr.Message = envelope.Errors[0].Message
+	} else {
+		apiErr.Message = string(body)
+	}
+	return apiErr
+}
@@ -0,0 +1,27 @@
+// Package dns abstracts DNS record management for tunnel hostnames behind a
+// Provider interface, so main can reconcile records without talking to the
+// Cloudflare API directly.
+package dns
+
+import "context"
+
+// Record is a DNS record as returned by a Provider's List.
+type Record struct {
+	ID      string
+	Type    string
+	Name    string
+	Content string
+	Proxied bool
+	TTL     int
+}
+
+// Provider reconciles DNS records for tunnel hostnames.
+type Provider interface {
+	// Ensure creates hostname's record pointing at target if it doesn't
+	// exist, or updates it if its content differs.
+	Ensure(ctx context.Context, hostname, target string) error
+	// Delete removes hostname's record, if one exists.
+	Delete(ctx context.Context, hostname string) error
+	// List returns every record the provider manages.
+	List(ctx context.Context) ([]Record, error)
+}
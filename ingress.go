@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OriginRequestConfig mirrors cloudflared's per-rule originRequest overrides.
+type OriginRequestConfig struct {
+	ConnectTimeout         string `yaml:"connectTimeout,omitempty" json:"connectTimeout,omitempty"`
+	TLSTimeout             string `yaml:"tlsTimeout,omitempty" json:"tlsTimeout,omitempty"`
+	NoTLSVerify            bool   `yaml:"noTLSVerify,omitempty" json:"noTLSVerify,omitempty"`
+	HTTPHostHeader         string `yaml:"httpHostHeader,omitempty" json:"httpHostHeader,omitempty"`
+	OriginServerName       string `yaml:"originServerName,omitempty" json:"originServerName,omitempty"`
+	CAPool                 string `yaml:"caPool,omitempty" json:"caPool,omitempty"`
+	DisableChunkedEncoding bool   `yaml:"disableChunkedEncoding,omitempty" json:"disableChunkedEncoding,omitempty"`
+	ProxyType              string `yaml:"proxyType,omitempty" json:"proxyType,omitempty"`
+}
+
+// WeightedService is one load-balancing target behind an ingress rule.
+type WeightedService struct {
+	Service string `yaml:"service" json:"service"`
+	Weight  int    `yaml:"weight,omitempty" json:"weight,omitempty"`
+}
+
+// IngressRule ties a hostname+path pair to one or more origin services.
+// A rule with no Hostname and no Path is the catch-all fallback and must
+// be the last entry in an IngressConfig.
+type IngressRule struct {
+	Hostname      string               `yaml:"hostname,omitempty" json:"hostname,omitempty"`
+	Path          string               `yaml:"path,omitempty" json:"path,omitempty"`
+	Service       string               `yaml:"service,omitempty" json:"service,omitempty"`
+	Services      []WeightedService    `yaml:"services,omitempty" json:"services,omitempty"`
+	OriginRequest *OriginRequestConfig `yaml:"originRequest,omitempty" json:"originRequest,omitempty"`
+
+	// AccessProtected marks a tcp/ssh rule as sitting behind a Cloudflare
+	// Access policy; clients reach it via the access subpackage (e.g. the
+	// `access ssh` subcommand) rather than connecting to Service directly.
+	AccessProtected bool `yaml:"accessProtected,omitempty" json:"accessProtected,omitempty"`
+
+	// next tracks round-robin position across Services; skipped by (un)marshalling.
+	next int `yaml:"-" json:"-"`
+}
+
+// IngressConfig is the top-level shape of a -ingress file.
+type IngressConfig struct {
+	Rules []IngressRule `yaml:"ingress"`
+}
+
+// loadIngressFile reads a YAML or JSON ingress file. JSON is valid YAML, so
+// a single unmarshaller handles both.
+func loadIngressFile(path string) (*IngressConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ingress file: %w", err)
+	}
+	var cfg IngressConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse ingress file: %w", err)
+	}
+	if err := validateIngress(cfg.Rules); err != nil {
+		return nil, fmt.Errorf("invalid ingress file %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// validateIngress enforces the same shape cloudflared requires: every rule
+// but the last must have a hostname, paths must be valid regexes, and the
+// last rule must be a catch-all (no hostname, no path).
+func validateIngress(rules []IngressRule) error {
+	if len(rules) == 0 {
+		return fmt.Errorf("at least one ingress rule is required")
+	}
+	for i, r := range rules {
+		last := i == len(rules)-1
+		if last {
+			if r.Hostname != "" || r.Path != "" {
+				return fmt.Errorf("last rule must be a catch-all with no hostname or path")
+			}
+		} else if r.Hostname == "" {
+			return fmt.Errorf("rule %d: hostname is required for all but the last rule", i)
+		}
+		if r.Path != "" {
+			if _, err := regexp.Compile(r.Path); err != nil {
+				return fmt.Errorf("rule %d: path %q is not a valid regex: %w", i, r.Path, err)
+			}
+		}
+		if r.Service == "" && len(r.Services) == 0 {
+			return fmt.Errorf("rule %d: service or services is required", i)
+		}
+		if r.Service != "" && len(r.Services) > 0 {
+			return fmt.Errorf("rule %d: specify either service or services, not both", i)
+		}
+		if len(r.Services) > 1 {
+			for _, s := range r.Services {
+				if !strings.HasPrefix(s.Service, "tcp://") {
+					return fmt.Errorf("rule %d: weighted load balancing across services only supports tcp:// backends, got %q -- "+
+						"the local balancer proxies raw TCP and cannot apply TLS or originRequest overrides", i, s.Service)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// pickService resolves the origin to dial for a rule, weighted round-robin
+// across Services when more than one target is configured: a Service with
+// weight 3 is picked three times out of every full cycle, in a fixed
+// repeating order rather than at random. next holds the cursor position
+// within that cycle and advances on every call.
+func (r *IngressRule) pickService() string {
+	if r.Service != "" {
+		return r.Service
+	}
+	total := 0
+	for _, s := range r.Services {
+		w := s.Weight
+		if w <= 0 {
+			w = 1
+		}
+		total += w
+	}
+	if total == 0 {
+		return r.Services[0].Service
+	}
+	pick := r.next % total
+	r.next++
+	for _, s := range r.Services {
+		w := s.Weight
+		if w <= 0 {
+			w = 1
+		}
+		if pick < w {
+			return s.Service
+		}
+		pick -= w
+	}
+	return r.Services[len(r.Services)-1].Service
+}
+
+// routesToIngress converts the legacy single-hostname Route list into an
+// IngressConfig with a fallback rule, for callers that haven't moved to a
+// -ingress file yet.
+func routesToIngress(routes []Route) IngressConfig {
+	var cfg IngressConfig
+	for _, r := range routes {
+		cfg.Rules = append(cfg.Rules, IngressRule{Hostname: r.Hostname, Service: r.Service})
+	}
+	fallback := "tcp://localhost:0"
+	if len(routes) > 0 && (strings.HasPrefix(routes[0].Service, "http://") ||
+		strings.HasPrefix(routes[0].Service, "https://")) {
+		fallback = "http_status:404"
+	}
+	cfg.Rules = append(cfg.Rules, IngressRule{Service: fallback})
+	return cfg
+}
+
+// fileIngressConfig is the on-disk shape of a tunnel's config file: the
+// cloudflared tunnel/credentials-file header plus the ingress rule set.
+type fileIngressConfig struct {
+	Tunnel          string        `yaml:"tunnel"`
+	CredentialsFile string        `yaml:"credentials-file"`
+	Ingress         []IngressRule `yaml:"ingress"`
+}
+
+// writeIngressConfigFile persists the full ingress config, including every
+// weighted Services entry, as the canonical source of truth for this
+// tunnel -- this is what loadIngressFile reads back on the "reuse previous
+// config" path, so nothing here may be resolved/collapsed at write time.
+// The cloudflared subprocess itself runs against a separate,
+// runtime-resolved config (see prepareRuntimeIngress), since it only
+// understands one service per rule.
+func writeIngressConfigFile(tunnelID, credentialsPath string, cfg IngressConfig) string {
+	if err := validateIngress(cfg.Rules); err != nil {
+		logger.Fatal().Err(err).Str("tunnelID", tunnelID).Msg("Invalid ingress config")
+	}
+
+	configPath := fmt.Sprintf("./%s-config.yml", tunnelID)
+	out := fileIngressConfig{Tunnel: tunnelID, CredentialsFile: credentialsPath, Ingress: cfg.Rules}
+	data, err := yaml.Marshal(out)
+	if err != nil {
+		logger.Fatal().Err(err).Str("tunnelID", tunnelID).Msg("Failed to marshal ingress config")
+	}
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		logger.Fatal().Err(err).Str("tunnelID", tunnelID).Msg("Failed to write config file")
+	}
+
+	logger.Info().Str("tunnelID", tunnelID).Str("config", configPath).Msg("Config file written")
+	return configPath
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// prepareRuntimeIngress resolves cfg into the config cloudflared actually
+// runs against. cloudflared's ingress only understands one service per
+// rule, so every rule with more than one weighted Services entry gets a
+// local round-robin balancer in front of it instead: the balancer dials the
+// real backends, and the rule written here points at the balancer's local
+// address. The balancer is L4-only (see proxyToOrigin), so validateIngress
+// restricts weighted services to tcp:// backends -- cfg is assumed already
+// validated by the time it reaches here. This file is separate from the one
+// writeIngressConfigFile persists, which keeps the full, lossless Services
+// list for future runs.
+func prepareRuntimeIngress(tunnelID, credentialsPath string, cfg IngressConfig) (string, error) {
+	rules := make([]IngressRule, len(cfg.Rules))
+	for i, r := range cfg.Rules {
+		if len(r.Services) < 2 {
+			rules[i] = r
+			if len(r.Services) == 1 {
+				rules[i].Service = r.Services[0].Service
+				rules[i].Services = nil
+			}
+			continue
+		}
+
+		balanced := r
+		addr, err := startLocalBalancer(&balanced)
+		if err != nil {
+			return "", fmt.Errorf("rule %d: %w", i, err)
+		}
+		logger.Info().Str("hostname", r.Hostname).Str("originURL", "tcp://"+addr).
+			Int("backends", len(r.Services)).Msg("started local load balancer for weighted services")
+
+		// OriginRequest is intentionally dropped here: validateIngress only
+		// allows tcp:// backends behind a weighted balancer, so there is no
+		// HTTP request to apply httpHostHeader/noTLSVerify/etc. to.
+		rules[i] = IngressRule{
+			Hostname:        r.Hostname,
+			Path:            r.Path,
+			Service:         "tcp://" + addr,
+			AccessProtected: r.AccessProtected,
+		}
+	}
+
+	path := fmt.Sprintf("./%s-runtime.yml", tunnelID)
+	out := fileIngressConfig{Tunnel: tunnelID, CredentialsFile: credentialsPath, Ingress: rules}
+	data, err := yaml.Marshal(out)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal runtime ingress config: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write runtime ingress config: %w", err)
+	}
+	return path, nil
+}
+
+// startLocalBalancer listens on an ephemeral local port and, for every
+// accepted connection, round-robins across rule's Services (via its own
+// pickService/next cursor) and proxies bytes to whichever backend it picked.
+func startLocalBalancer(rule *IngressRule) (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("failed to start local balancer: %w", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go proxyToOrigin(conn, rule.pickService())
+		}
+	}()
+	return listener.Addr().String(), nil
+}
+
+// proxyToOrigin pipes raw bytes between an accepted local connection and the
+// chosen backend service. This is an L4 proxy: it has no notion of TLS or
+// HTTP, which is why validateIngress rejects http(s):// backends for
+// weighted services rather than letting them reach here mishandled.
+func proxyToOrigin(conn net.Conn, service string) {
+	defer conn.Close()
+	target := strings.TrimPrefix(service, "tcp://")
+	origin, err := net.Dial("tcp", target)
+	if err != nil {
+		logger.Warn().Err(err).Str("originURL", service).Msg("load balancer failed to dial backend")
+		return
+	}
+	defer origin.Close()
+
+	errc := make(chan error, 2)
+	go func() { _, err := io.Copy(origin, conn); errc <- err }()
+	go func() { _, err := io.Copy(conn, origin); errc <- err }()
+	<-errc
+}
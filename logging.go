@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// newLogger builds the process-wide zerolog.Logger from the -loglevel,
+// -logfile, and -logformat flags. format is "console" for human-readable
+// output or "json" for machine-parseable lines; logfile is written to
+// instead of stdout when non-empty.
+func newLogger(level, logfile, format string) zerolog.Logger {
+	var out io.Writer = os.Stdout
+	if logfile != "" {
+		f, err := os.OpenFile(logfile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fallback := zerolog.New(os.Stderr).With().Timestamp().Logger()
+			fallback.Error().Err(err).Str("logfile", logfile).Msg("failed to open log file, falling back to stdout")
+		} else {
+			out = f
+		}
+	}
+	if format == "console" {
+		out = zerolog.ConsoleWriter{Out: out, TimeFormat: time.RFC3339}
+	}
+
+	lvl, err := zerolog.ParseLevel(level)
+	if err != nil {
+		lvl = zerolog.InfoLevel
+	}
+
+	return zerolog.New(out).Level(lvl).With().Timestamp().Logger()
+}
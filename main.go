@@ -1,42 +1,36 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
-	"log"
-	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
-	"strings"
 	"syscall"
+	"time"
 
+	"github.com/anik-ghosh-au7/cloudflare-tunnel/cliutil"
+	"github.com/anik-ghosh-au7/cloudflare-tunnel/dns"
+	"github.com/anik-ghosh-au7/cloudflare-tunnel/tunnel"
 	"github.com/armon/go-socks5"
+	"github.com/rs/zerolog"
 )
 
-// DNSRecord holds the information for Cloudflare DNS records.
-type DNSRecord struct {
-	ID    string `json:"id"`
-	Type  string `json:"type"`
-	Name  string `json:"name"`
-	Value string `json:"content"`
-}
+// logger is the process-wide structured logger; main reconfigures it from
+// -loglevel/-logfile/-logformat right after flag parsing. The access
+// subcommand is dispatched before that and keeps this default.
+var logger = zerolog.New(os.Stderr).With().Timestamp().Logger()
 
 // TunnelCredentials holds the tunnel credentials.
-type TunnelCredentials struct {
-	AccountTag   string `json:"AccountTag"`
-	TunnelSecret string `json:"TunnelSecret"`
-	TunnelID     string `json:"TunnelID"`
-}
+type TunnelCredentials = tunnel.Credentials
 
-// APIKeys holds the API Token and Zone ID from the JSON file.
+// APIKeys holds the API Token, account, and Zone ID from the JSON file.
 type APIKeys struct {
-	ApiToken string `json:"ApiToken"`
-	ZoneID   string `json:"ZoneId"`
+	ApiToken   string `json:"ApiToken"`
+	AccountTag string `json:"AccountTag"`
+	ZoneID     string `json:"ZoneId"`
 }
 
 // loadAPIKeys loads the API keys from the specified file.
@@ -49,8 +43,8 @@ func loadAPIKeys(filePath string) (*APIKeys, error) {
 	if err := json.Unmarshal(data, &keys); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal API keys: %w", err)
 	}
-	if keys.ApiToken == "" || keys.ZoneID == "" {
-		return nil, fmt.Errorf("ApiToken or ZoneId missing in API keys file")
+	if keys.ApiToken == "" || keys.AccountTag == "" || keys.ZoneID == "" {
+		return nil, fmt.Errorf("ApiToken, AccountTag, or ZoneId missing in API keys file")
 	}
 	return &keys, nil
 }
@@ -77,128 +71,106 @@ func saveCredentials(filePath string, creds *TunnelCredentials) error {
 	return os.WriteFile(filePath, data, 0600)
 }
 
-// authenticateCloudflare performs authentication with Cloudflare.
-func authenticateCloudflare() {
-	log.Println("Authenticating with Cloudflare...")
-	cmd := exec.Command("cloudflared", "tunnel", "login")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		log.Fatalf("Cloudflare authentication failed: %v", err)
-	}
-	log.Println("Cloudflare authentication successful.")
-}
-
-// createTunnel creates a new tunnel and returns its credentials.
-func createTunnel(ctx context.Context, tunnelName, credentialsPath string) *TunnelCredentials {
-	log.Printf("Creating tunnel %s...\n", tunnelName)
-	cmd := exec.CommandContext(ctx,
-		"cloudflared", "tunnel",
-		"--credentials-file", credentialsPath,
-		"create", tunnelName,
-	)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		log.Fatalf("Failed to create tunnel: %v", err)
-	}
-	creds, err := loadCredentials(credentialsPath)
+// createTunnel creates a new tunnel via the Cloudflare API and persists its
+// credentials, without requiring the cloudflared binary or a browser login.
+func createTunnel(ctx context.Context, client *tunnel.Client, tunnelName, credentialsPath string) *TunnelCredentials {
+	logger.Info().Str("tunnel", tunnelName).Msg("Creating tunnel...")
+	creds, err := client.Create(ctx, tunnelName)
 	if err != nil {
-		log.Fatalf("Failed to load tunnel credentials: %v", err)
+		logger.Fatal().Err(err).Str("tunnel", tunnelName).Msg("Failed to create tunnel")
+	}
+	if err := saveCredentials(credentialsPath, creds); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to save credentials")
 	}
-	log.Println("Tunnel created successfully.")
+	logger.Info().Str("tunnelID", creds.TunnelID).Msg("Tunnel created successfully.")
 	return creds
 }
 
-// cloudflareAPIRequest makes a request to the Cloudflare API.
-func cloudflareAPIRequest(method, url, apiToken string, payload []byte) ([]byte, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, bytes.NewBuffer(payload))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create API request: %w", err)
+// ensureDNSRecord ensures a single hostname's CNAME points at the tunnel,
+// logging what the provider did.
+func ensureDNSRecord(provider dns.Provider, hostname, tunnelID string) {
+	target := tunnelID + ".cfargotunnel.com"
+	if err := provider.Ensure(context.Background(), hostname, target); err != nil {
+		logger.Fatal().Err(err).Str("hostname", hostname).Str("tunnelID", tunnelID).Msg("Failed to ensure DNS record")
 	}
-	req.Header.Set("Authorization", "Bearer "+apiToken)
-	req.Header.Set("Content-Type", "application/json")
+	logger.Info().Str("hostname", hostname).Str("tunnelID", tunnelID).Msg("DNS record reconciled")
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("API request failed: %w", err)
+// ensureDNSRecords reconciles several hostnames for the same tunnel in a
+// single pass, used when protocol=all or an ingress file lists more than
+// one hostname.
+func ensureDNSRecords(provider *dns.CloudflareProvider, tunnelID string, hostnames []string) {
+	if len(hostnames) == 0 {
+		return
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("API error: %s", body)
+	target := tunnelID + ".cfargotunnel.com"
+	targets := make(map[string]string, len(hostnames))
+	for _, h := range hostnames {
+		targets[h] = target
+	}
+	if err := provider.EnsureAll(context.Background(), targets); err != nil {
+		logger.Fatal().Err(err).Str("tunnelID", tunnelID).Msg("Failed to reconcile DNS records")
 	}
-	return body, nil
+	logger.Info().Str("tunnelID", tunnelID).Int("hostnames", len(targets)).Msg("DNS records reconciled")
 }
 
-// dnsRecordExists checks if a DNS record for the domain already exists.
-func dnsRecordExists(zoneID, domain, apiToken string) bool {
-	url := fmt.Sprintf(
-		"https://api.cloudflare.com/client/v4/zones/%s/dns_records?name=%s",
-		zoneID, domain,
-	)
-	resp, err := cloudflareAPIRequest("GET", url, apiToken, nil)
-	if err != nil {
-		log.Fatalf("Failed to query DNS record: %v", err)
+// resolveCredentials prefers a tunnel token (-token or TUNNEL_TOKEN) over
+// the credentials.json file, so tunnels can run without ever calling
+// `cloudflared tunnel login`.
+func resolveCredentials(token, credentialsPath string) (*TunnelCredentials, error) {
+	if token != "" {
+		return tunnel.ParseToken(token)
 	}
-	var result struct {
-		Success bool        `json:"success"`
-		Result  []DNSRecord `json:"result"`
-	}
-	if err := json.Unmarshal(resp, &result); err != nil {
-		log.Fatalf("Failed to parse DNS record response: %v", err)
+	return loadCredentials(credentialsPath)
+}
+
+// fatal prints usage errors as a clean one-line message and everything else
+// as a regular fatal log, then exits.
+func fatal(err error) {
+	var usageErr *cliutil.UsageError
+	if errors.As(err, &usageErr) {
+		fmt.Fprintln(os.Stderr, usageErr.Error())
+		os.Exit(1)
 	}
-	return len(result.Result) > 0
+	logger.Fatal().Err(err).Msg("fatal error")
 }
 
-// ensureDNSRecord ensures that the DNS record exists for the tunnel.
-func ensureDNSRecord(zoneID, domain, tunnelID, apiToken string) {
-	if dnsRecordExists(zoneID, domain, apiToken) {
-		log.Printf("DNS record for %s already exists, skipping.\n", domain)
+// materializeCredentialsFile writes creds to credentialsPath if no file
+// exists there yet; an existing file is left as it is, whatever it holds.
+// creds usually already comes from that file, but when it was
+// resolved from -token/TUNNEL_TOKEN instead, cloudflared still needs an
+// actual credentials.json on disk to run against.
+func materializeCredentialsFile(credentialsPath string, creds *TunnelCredentials) {
+	if _, err := os.Stat(credentialsPath); err == nil {
 		return
 	}
-	record := DNSRecord{
-		Type:  "CNAME",
-		Name:  domain,
-		Value: tunnelID + ".cfargotunnel.com",
+	if err := saveCredentials(credentialsPath, creds); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to write credentials file for cloudflared")
 	}
-	payload, err := json.Marshal(record)
-	if err != nil {
-		log.Fatalf("Failed to marshal DNS record: %v", err)
-	}
-	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records", zoneID)
-	if _, err := cloudflareAPIRequest("POST", url, apiToken, payload); err != nil {
-		log.Fatalf("Failed to create DNS record: %v", err)
-	}
-	log.Printf("Created DNS record for %s.\n", domain)
 }
 
-// startTunnel starts the Cloudflare Tunnel using the given config file.
-func startTunnel(ctx context.Context, configPath string) *exec.Cmd {
-	cmd := exec.CommandContext(ctx,
-		"cloudflared", "--config", configPath, "tunnel", "run",
-	)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Start(); err != nil {
-		log.Fatalf("Failed to start Cloudflare Tunnel: %v", err)
-	}
-	log.Println("Cloudflared tunnel started.")
-	return cmd
+// startTunnel runs cloudflared against configPath, across haConnections HA
+// connections to the edge, and returns the manager owning the subprocess;
+// callers call waitWithGrace after cancelling ctx for a clean shutdown.
+func startTunnel(ctx context.Context, creds *TunnelCredentials, credentialsPath, configPath string, haConnections int) *tunnel.Manager {
+	manager := tunnel.NewManager(creds.TunnelID, credentialsPath, configPath, logger)
+	go manager.RunHA(ctx, haConnections)
+	logger.Info().Str("tunnelID", creds.TunnelID).Int("haConnections", haConnections).Msg("Tunnel connections started")
+	return manager
 }
 
-// logoutCloudflare logs out of Cloudflare.
-func logoutCloudflare() {
-	log.Println("Logging out of Cloudflare...")
-	cmd := exec.Command("cloudflared", "tunnel", "logout")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		log.Printf("Logout failed: %v", err)
-	} else {
-		log.Println("Successfully logged out.")
+// waitWithGrace waits for the manager to finish draining in-flight
+// connections, up to gracePeriod, logging if the deadline is hit first.
+func waitWithGrace(manager *tunnel.Manager, gracePeriod time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		manager.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(gracePeriod):
+		logger.Warn().Dur("gracePeriod", gracePeriod).Msg("grace period elapsed before tunnel connections drained")
 	}
 }
 
@@ -208,55 +180,28 @@ type Route struct {
 	Service  string
 }
 
-// writeConfigFile writes a multiâ€‘rule ingress YAML for the tunnel.
-func writeConfigFile(
-	tunnelID, credentialsPath string,
-	routes []Route,
-) string {
-	configPath := fmt.Sprintf("./%s-config.yml", tunnelID)
-	f, err := os.Create(configPath)
-	if err != nil {
-		log.Fatalf("Failed to create config file: %v", err)
-	}
-	defer f.Close()
-
-	fmt.Fprintf(f, "tunnel: %s\n", tunnelID)
-	fmt.Fprintf(f, "credentials-file: %s\n", credentialsPath)
-	fmt.Fprintln(f, "ingress:")
-	for _, r := range routes {
-		fmt.Fprintf(f, "  - hostname: %s\n", r.Hostname)
-		fmt.Fprintf(f, "    service: %s\n", r.Service)
-	}
-
-	// fallback: HTTP 404 for HTTP ingress, else drop TCP
-	fallback := "tcp://localhost:0"
-	if strings.HasPrefix(routes[0].Service, "http://") ||
-		strings.HasPrefix(routes[0].Service, "https://") {
-		fallback = "http_status:404"
-	}
-	fmt.Fprintf(f, "  - service: %s\n", fallback)
-
-	log.Printf("Config file written to %s\n", configPath)
-	return configPath
-}
-
 // startSocks5 spins up a SOCKS5 proxy handling both TCP & UDP.
 func startSocks5(port int) {
 	conf := &socks5.Config{}
 	server, err := socks5.New(conf)
 	if err != nil {
-		log.Fatalf("Failed to create SOCKS5 server: %v", err)
+		logger.Fatal().Err(err).Msg("Failed to create SOCKS5 server")
 	}
 	go func() {
 		addr := fmt.Sprintf("127.0.0.1:%d", port)
-		log.Printf("SOCKS5 proxy listening on %s\n", addr)
+		logger.Info().Str("protocol", "socks5").Str("originURL", addr).Msg("SOCKS5 proxy listening")
 		if err := server.ListenAndServe("tcp", addr); err != nil {
-			log.Fatalf("SOCKS5 server error: %v", err)
+			logger.Fatal().Err(err).Msg("SOCKS5 server error")
 		}
 	}()
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "access" {
+		dispatchAccess(os.Args[2:])
+		return
+	}
+
 	portFlag := flag.Int("port", 0, "Port to forward (e.g., 22, 5173)")
 	tunnelName := flag.String("tunnel", "", "Cloudflare Tunnel name")
 	domain := flag.String("domain", "", "Root domain (e.g., anik.cc)")
@@ -265,81 +210,131 @@ func main() {
 	protocol := flag.String("protocol", "http", "Protocol to forward: http, tcp, or all")
 	socks5Port := flag.Int("socks5-port", 1080, "Local SOCKS5 port (only for protocol=all)")
 	proxyDomain := flag.String("proxy-domain", "", "Hostname for SOCKS5 proxy (protocol=all)")
+	ingressPath := flag.String("ingress", "", "Path to a multi-rule ingress YAML/JSON file (overrides -protocol)")
+	dnsProxied := flag.Bool("dns-proxied", true, "Whether created/updated DNS records are proxied through Cloudflare")
+	dnsTTL := flag.Int("dns-ttl", 1, "DNS record TTL in seconds (1 means automatic)")
+	tokenFlag := flag.String("token", "", "Base64-encoded tunnel token (or set TUNNEL_TOKEN); skips credentials.json")
+	logLevel := flag.String("loglevel", "info", "Log level: debug, info, warn, error")
+	logFile := flag.String("logfile", "", "Path to write logs to (default: stdout)")
+	logFormat := flag.String("logformat", "console", "Log output format: console or json")
+	haConnections := flag.Int("ha-connections", 4, "Number of HA connections to the Cloudflare edge")
+	gracePeriod := flag.Duration("grace-period", 30*time.Second, "How long to wait for in-flight connections to drain on shutdown")
+	supervisorConfigPath := flag.String("supervisor-config", "", "Path to a multi-tunnel supervisor config; enables supervisor mode")
+	metricsAddr := flag.String("metrics-addr", "", "Address to serve /ready and /metrics on in supervisor mode (default :8080)")
 
 	flag.Parse()
 
+	logger = newLogger(*logLevel, *logFile, *logFormat)
+
+	if *supervisorConfigPath != "" {
+		runSupervisor(*supervisorConfigPath, *haConnections, *gracePeriod, *metricsAddr)
+		return
+	}
+
+	token := *tokenFlag
+	if token == "" {
+		token = os.Getenv("TUNNEL_TOKEN")
+	}
+
 	// reuse previous config if flags missing
-	if *portFlag == 0 || *tunnelName == "" || *domain == "" {
-		creds, err := loadCredentials(*credentialsPath)
+	if (*portFlag == 0 && *ingressPath == "") || *tunnelName == "" || *domain == "" {
+		creds, err := resolveCredentials(token, *credentialsPath)
 		if err != nil {
-			log.Fatalf("Previous configuration not found: %v", err)
+			fatal(fmt.Errorf("previous configuration not found: %w", err))
 		}
 		cfg := fmt.Sprintf("./%s-config.yml", creds.TunnelID)
-		if _, err := os.Stat(cfg); os.IsNotExist(err) {
-			log.Fatalf("Config %s not found", cfg)
+		ingressCfg, err := loadIngressFile(cfg)
+		if err != nil {
+			logger.Fatal().Err(err).Str("tunnelID", creds.TunnelID).Msgf("Config %s not found or invalid", cfg)
+		}
+		runtimePath, err := prepareRuntimeIngress(creds.TunnelID, *credentialsPath, *ingressCfg)
+		if err != nil {
+			logger.Fatal().Err(err).Str("tunnelID", creds.TunnelID).Msg("Failed to prepare runtime ingress config")
 		}
+		materializeCredentialsFile(*credentialsPath, creds)
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
-		defer logoutCloudflare()
-		cmd := startTunnel(ctx, cfg)
+		manager := startTunnel(ctx, creds, *credentialsPath, runtimePath, *haConnections)
 		sig := make(chan os.Signal, 1)
 		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 		<-sig
 		cancel()
-		cmd.Wait()
+		waitWithGrace(manager, *gracePeriod)
 		return
 	}
 
 	// load or create tunnel + DNS
 	apiKeys, err := loadAPIKeys(*apiKeysPath)
 	if err != nil {
-		log.Fatalf("Failed to load API keys: %v", err)
+		logger.Fatal().Err(err).Msg("Failed to load API keys")
 	}
-	creds, err := loadCredentials(*credentialsPath)
+	client := tunnel.NewClient(apiKeys.ApiToken, apiKeys.AccountTag)
+	dnsProvider := dns.NewCloudflareProvider(apiKeys.ZoneID, apiKeys.ApiToken, *dnsProxied, *dnsTTL)
+	creds, err := resolveCredentials(token, *credentialsPath)
 	if err != nil {
-		authenticateCloudflare()
-		creds = createTunnel(context.Background(), *tunnelName, *credentialsPath)
-		if err := saveCredentials(*credentialsPath, creds); err != nil {
-			log.Fatalf("Failed to save credentials: %v", err)
+		var usageErr *cliutil.UsageError
+		if errors.As(err, &usageErr) {
+			fatal(err)
 		}
+		creds = createTunnel(context.Background(), client, *tunnelName, *credentialsPath)
 	}
-	ensureDNSRecord(apiKeys.ZoneID, *domain, creds.TunnelID, apiKeys.ApiToken)
 
-	// build ingress routes
-	var routes []Route
-	switch *protocol {
-	case "http":
-		routes = []Route{{Hostname: *domain, Service: fmt.Sprintf("http://localhost:%d", *portFlag)}}
-	case "tcp":
-		routes = []Route{{Hostname: *domain, Service: fmt.Sprintf("tcp://localhost:%d", *portFlag)}}
-	case "all":
-		if *proxyDomain == "" {
-			log.Fatal("proxy-domain is required when protocol=all")
-		}
-		// ensure DNS for proxy
-		ensureDNSRecord(apiKeys.ZoneID, *proxyDomain, creds.TunnelID, apiKeys.ApiToken)
-		routes = append(routes, Route{*domain, fmt.Sprintf("tcp://localhost:%d", *portFlag)})
-		routes = append(routes, Route{*proxyDomain, fmt.Sprintf("tcp://localhost:%d", *socks5Port)})
-	default:
-		log.Fatalf("Unsupported protocol: %s", *protocol)
-	}
-
-	// write config & start services
+	// build ingress & start services
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	defer logoutCloudflare()
 
-	configPath := writeConfigFile(creds.TunnelID, *credentialsPath, routes)
-	if *protocol == "all" {
+	var ingressCfg IngressConfig
+	if *ingressPath != "" {
+		loaded, err := loadIngressFile(*ingressPath)
+		if err != nil {
+			logger.Fatal().Err(err).Str("ingress", *ingressPath).Msg("Failed to load ingress file")
+		}
+		ingressCfg = *loaded
+		hostnames := []string{*domain}
+		for _, r := range ingressCfg.Rules {
+			if r.Hostname != "" {
+				hostnames = append(hostnames, r.Hostname)
+			}
+		}
+		ensureDNSRecords(dnsProvider, creds.TunnelID, hostnames)
+	} else {
+		// build ingress routes
+		var routes []Route
+		switch *protocol {
+		case "http":
+			ensureDNSRecord(dnsProvider, *domain, creds.TunnelID)
+			routes = []Route{{Hostname: *domain, Service: fmt.Sprintf("http://localhost:%d", *portFlag)}}
+		case "tcp":
+			ensureDNSRecord(dnsProvider, *domain, creds.TunnelID)
+			routes = []Route{{Hostname: *domain, Service: fmt.Sprintf("tcp://localhost:%d", *portFlag)}}
+		case "all":
+			if *proxyDomain == "" {
+				logger.Fatal().Msg("proxy-domain is required when protocol=all")
+			}
+			ensureDNSRecords(dnsProvider, creds.TunnelID, []string{*domain, *proxyDomain})
+			routes = append(routes, Route{*domain, fmt.Sprintf("tcp://localhost:%d", *portFlag)})
+			routes = append(routes, Route{*proxyDomain, fmt.Sprintf("tcp://localhost:%d", *socks5Port)})
+		default:
+			logger.Fatal().Str("protocol", *protocol).Msg("Unsupported protocol")
+		}
+		ingressCfg = routesToIngress(routes)
+	}
+	writeIngressConfigFile(creds.TunnelID, *credentialsPath, ingressCfg)
+	runtimePath, err := prepareRuntimeIngress(creds.TunnelID, *credentialsPath, ingressCfg)
+	if err != nil {
+		logger.Fatal().Err(err).Str("tunnelID", creds.TunnelID).Msg("Failed to prepare runtime ingress config")
+	}
+	if *protocol == "all" && *ingressPath == "" {
 		startSocks5(*socks5Port)
 	}
-	cmd := startTunnel(ctx, configPath)
+	materializeCredentialsFile(*credentialsPath, creds)
+	manager := startTunnel(ctx, creds, *credentialsPath, runtimePath, *haConnections)
 
 	// wait for interrupt
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 	<-sig
 	cancel()
-	cmd.Wait()
-	log.Println("Cloudflare Tunnel stopped.")
+	waitWithGrace(manager, *gracePeriod)
+	logger.Info().Str("tunnelID", creds.TunnelID).Msg("Cloudflare Tunnel stopped")
 }
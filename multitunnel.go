@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/anik-ghosh-au7/cloudflare-tunnel/supervisor"
+	"gopkg.in/yaml.v3"
+)
+
+// multiTunnelFile is the -supervisor-config format: a list of independently
+// configured tunnels, each with its own credentials and ingress file.
+type multiTunnelFile struct {
+	HAConnections int                `yaml:"haConnections"`
+	Tunnels       []multiTunnelEntry `yaml:"tunnels"`
+}
+
+type multiTunnelEntry struct {
+	Name            string `yaml:"name"`
+	CredentialsPath string `yaml:"credentials"`
+	IngressPath     string `yaml:"ingress"`
+}
+
+// loadSupervisorConfig reads a -supervisor-config file and resolves each
+// entry's credentials and ingress rules into a supervisor.Config.
+func loadSupervisorConfig(path string) (supervisor.Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return supervisor.Config{}, fmt.Errorf("failed to read supervisor config: %w", err)
+	}
+	var file multiTunnelFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return supervisor.Config{}, fmt.Errorf("failed to parse supervisor config: %w", err)
+	}
+
+	cfg := supervisor.Config{HAConnections: file.HAConnections}
+	for _, entry := range file.Tunnels {
+		creds, err := loadCredentials(entry.CredentialsPath)
+		if err != nil {
+			return supervisor.Config{}, fmt.Errorf("tunnel %q: %w", entry.Name, err)
+		}
+		ingressCfg, err := loadIngressFile(entry.IngressPath)
+		if err != nil {
+			return supervisor.Config{}, fmt.Errorf("tunnel %q: %w", entry.Name, err)
+		}
+		runtimePath, err := prepareRuntimeIngress(creds.TunnelID, entry.CredentialsPath, *ingressCfg)
+		if err != nil {
+			return supervisor.Config{}, fmt.Errorf("tunnel %q: %w", entry.Name, err)
+		}
+		cfg.Tunnels = append(cfg.Tunnels, supervisor.TunnelSpec{
+			Name:            entry.Name,
+			TunnelID:        creds.TunnelID,
+			CredentialsPath: entry.CredentialsPath,
+			ConfigPath:      runtimePath,
+		})
+	}
+	return cfg, nil
+}
+
+// runSupervisor loads a multi-tunnel config and runs it under a Supervisor
+// until interrupted, re-loading it on SIGHUP.
+func runSupervisor(configPath string, haConnections int, gracePeriod time.Duration, metricsAddr string) {
+	cfg, err := loadSupervisorConfig(configPath)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to load supervisor config")
+	}
+	if cfg.HAConnections <= 0 {
+		cfg.HAConnections = haConnections
+	}
+	if metricsAddr == "" {
+		metricsAddr = ":8080"
+	}
+
+	reload := func() (supervisor.Config, error) { return loadSupervisorConfig(configPath) }
+	sup := supervisor.New(cfg, reload, logger, metricsAddr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	sup.Run(ctx, gracePeriod)
+	logger.Info().Msg("Supervisor stopped")
+}
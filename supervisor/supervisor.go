@@ -0,0 +1,268 @@
+// Package supervisor manages multiple concurrent tunnels defined in a single
+// config file: it starts each tunnel's HA connections, restarts an
+// individual tunnel on failure with exponential backoff, reloads all
+// tunnels on SIGHUP without dropping existing connections (drain-and-swap),
+// and exposes /ready and /metrics endpoints.
+package supervisor
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/anik-ghosh-au7/cloudflare-tunnel/tunnel"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog"
+)
+
+// TunnelSpec is one tunnel entry in a supervisor config: the tunnel's ID,
+// its credentials file, and the ingress config file cloudflared should run
+// against.
+type TunnelSpec struct {
+	Name            string
+	TunnelID        string
+	CredentialsPath string
+	ConfigPath      string
+}
+
+// Config is the full set of tunnels a Supervisor manages, plus its runtime
+// knobs.
+type Config struct {
+	Tunnels       []TunnelSpec
+	HAConnections int
+}
+
+// ReloadFunc re-reads a supervisor's config file from disk, returning the
+// new desired state.
+type ReloadFunc func() (Config, error)
+
+var (
+	tunnelsUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudflare_tunnel_up",
+		Help: "Whether the supervisor is running a tunnel's cloudflared process (1) or restarting it after a failure (0).",
+	}, []string{"tunnel"})
+	reloadsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "cloudflare_tunnel_reloads_total",
+		Help: "Number of SIGHUP-triggered config reloads performed.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(tunnelsUp, reloadsTotal)
+}
+
+const (
+	minRestartBackoff = 1 * time.Second
+	maxRestartBackoff = 30 * time.Second
+	drainSettleDelay  = 2 * time.Second
+)
+
+type runningTunnel struct {
+	cancel  context.CancelFunc
+	manager *tunnel.Manager
+}
+
+// Supervisor runs a set of tunnels concurrently, restarting and reloading
+// them as needed.
+type Supervisor struct {
+	cfg        Config
+	reload     ReloadFunc
+	logger     zerolog.Logger
+	listenAddr string
+
+	mu      sync.Mutex
+	running map[string]*runningTunnel
+}
+
+// New builds a Supervisor for the given config. listenAddr is where /ready
+// and /metrics are served; an empty string disables the HTTP endpoints.
+// reload may be nil, which disables SIGHUP-triggered reloads.
+func New(cfg Config, reload ReloadFunc, logger zerolog.Logger, listenAddr string) *Supervisor {
+	if cfg.HAConnections <= 0 {
+		cfg.HAConnections = 4
+	}
+	return &Supervisor{
+		cfg:        cfg,
+		reload:     reload,
+		logger:     logger,
+		listenAddr: listenAddr,
+		running:    make(map[string]*runningTunnel),
+	}
+}
+
+// Run starts every tunnel and the HTTP endpoints, and blocks until ctx is
+// cancelled. On cancellation it waits up to gracePeriod for in-flight
+// connections to drain before returning.
+func (s *Supervisor) Run(ctx context.Context, gracePeriod time.Duration) {
+	for _, spec := range s.cfg.Tunnels {
+		s.startTunnel(ctx, spec)
+	}
+
+	var srv *http.Server
+	if s.listenAddr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/ready", s.handleReady)
+		mux.Handle("/metrics", promhttp.Handler())
+		srv = &http.Server{Addr: s.listenAddr, Handler: mux}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				s.logger.Error().Err(err).Msg("supervisor HTTP server failed")
+			}
+		}()
+	}
+
+	s.watchReload(ctx)
+
+	<-ctx.Done()
+	s.logger.Info().Dur("gracePeriod", gracePeriod).Msg("shutting down, waiting for connections to drain")
+	grace, cancelGrace := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancelGrace()
+	if srv != nil {
+		srv.Shutdown(grace)
+	}
+	done := make(chan struct{})
+	go func() {
+		s.waitAll()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-grace.Done():
+		s.logger.Warn().Dur("gracePeriod", gracePeriod).Msg("grace period elapsed before tunnel connections drained")
+	}
+}
+
+// startTunnel runs one tunnel's HA connections under its own cancellable
+// context, restarting it with exponential backoff if it ever exits early.
+func (s *Supervisor) startTunnel(parent context.Context, spec TunnelSpec) {
+	ctx, cancel := context.WithCancel(parent)
+	manager := tunnel.NewManager(spec.TunnelID, spec.CredentialsPath, spec.ConfigPath, s.logger)
+
+	s.mu.Lock()
+	s.running[spec.Name] = &runningTunnel{cancel: cancel, manager: manager}
+	s.mu.Unlock()
+
+	go s.runWithBackoff(ctx, cancel, spec, manager)
+}
+
+// runWithBackoff restarts spec's tunnel with exponential backoff whenever
+// its manager exits early. cancel is the same CancelFunc startTunnel
+// derived ctx from; it's re-stored in s.running on every restart so swap
+// can still cancel ctx (and with it, the current manager) after a restart.
+func (s *Supervisor) runWithBackoff(ctx context.Context, cancel context.CancelFunc, spec TunnelSpec, manager *tunnel.Manager) {
+	backoff := minRestartBackoff
+	for {
+		tunnelsUp.WithLabelValues(spec.Name).Set(1)
+		err := manager.RunHA(ctx, s.cfg.HAConnections)
+		if ctx.Err() != nil {
+			return
+		}
+		tunnelsUp.WithLabelValues(spec.Name).Set(0)
+
+		s.logger.Error().Err(err).Str("tunnel", spec.Name).Dur("backoff", backoff).
+			Msg("tunnel exited unexpectedly, restarting")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxRestartBackoff {
+			backoff = maxRestartBackoff
+		}
+
+		manager = tunnel.NewManager(spec.TunnelID, spec.CredentialsPath, spec.ConfigPath, s.logger)
+		s.mu.Lock()
+		s.running[spec.Name] = &runningTunnel{cancel: cancel, manager: manager}
+		s.mu.Unlock()
+	}
+}
+
+// watchReload listens for SIGHUP and performs a drain-and-swap reload:
+// the new tunnel set is brought up before the old one is torn down, so
+// existing connections keep serving traffic throughout.
+func (s *Supervisor) watchReload(ctx context.Context) {
+	if s.reload == nil {
+		return
+	}
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(sighup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sighup:
+				s.logger.Info().Msg("SIGHUP received, reloading config")
+				newCfg, err := s.reload()
+				if err != nil {
+					s.logger.Error().Err(err).Msg("failed to reload config, keeping existing tunnels")
+					continue
+				}
+				reloadsTotal.Inc()
+				s.swap(ctx, newCfg)
+			}
+		}
+	}()
+}
+
+func (s *Supervisor) swap(parent context.Context, newCfg Config) {
+	if newCfg.HAConnections <= 0 {
+		newCfg.HAConnections = 4
+	}
+
+	s.mu.Lock()
+	old := s.running
+	s.running = make(map[string]*runningTunnel)
+	s.cfg = newCfg
+	s.mu.Unlock()
+
+	keep := make(map[string]bool, len(newCfg.Tunnels))
+	for _, spec := range newCfg.Tunnels {
+		keep[spec.Name] = true
+		s.startTunnel(parent, spec)
+	}
+
+	// Give the new connections time to come up before draining the old ones.
+	time.Sleep(drainSettleDelay)
+	for name, rt := range old {
+		s.logger.Info().Str("tunnel", name).Msg("draining old tunnel connections")
+		rt.cancel()
+		rt.manager.Wait()
+		// A tunnel with the same name in newCfg already set this gauge to 1
+		// in runWithBackoff; only clear it here if reload actually dropped
+		// the tunnel, or we'd wipe the metric for the still-running one.
+		if !keep[name] {
+			tunnelsUp.DeleteLabelValues(name)
+		}
+	}
+}
+
+func (s *Supervisor) handleReady(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	n := len(s.running)
+	s.mu.Unlock()
+	if n == 0 {
+		http.Error(w, "no tunnels running", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+func (s *Supervisor) waitAll() {
+	s.mu.Lock()
+	running := make([]*runningTunnel, 0, len(s.running))
+	for _, rt := range s.running {
+		running = append(running, rt)
+	}
+	s.mu.Unlock()
+	for _, rt := range running {
+		rt.manager.Wait()
+	}
+}
@@ -0,0 +1,117 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/rs/zerolog"
+)
+
+// Manager runs a tunnel's data-plane connections by supervising a
+// `cloudflared tunnel run` subprocess against a written ingress config and
+// credentials file. Cloudflare's edge connection-registration and
+// stream-framing protocol is proprietary and undocumented, so this shells
+// out to cloudflared itself rather than reimplementing it in-process;
+// Client still talks to the public Tunnel API directly for create/delete/
+// list, and ParseToken still decodes tunnel tokens without cloudflared.
+type Manager struct {
+	TunnelID        string
+	CredentialsPath string
+	ConfigPath      string
+
+	logger zerolog.Logger
+	done   chan struct{}
+
+	mu  sync.Mutex
+	cmd *exec.Cmd
+}
+
+// NewManager builds a manager for the tunnel identified by tunnelID, whose
+// ingress is defined in the cloudflared config file at configPath and whose
+// credentials live at credentialsPath.
+func NewManager(tunnelID, credentialsPath, configPath string, logger zerolog.Logger) *Manager {
+	return &Manager{
+		TunnelID:        tunnelID,
+		CredentialsPath: credentialsPath,
+		ConfigPath:      configPath,
+		logger:          logger.With().Str("tunnelID", tunnelID).Logger(),
+		done:            make(chan struct{}),
+	}
+}
+
+// Run starts cloudflared with a single HA connection and blocks until it
+// exits or ctx is cancelled. It is equivalent to RunHA(ctx, 1).
+func (m *Manager) Run(ctx context.Context) error {
+	return m.RunHA(ctx, 1)
+}
+
+// RunHA starts cloudflared configured for haConnections edge connections and
+// blocks until it exits or ctx is cancelled. Cancelling ctx sends SIGTERM
+// rather than killing outright, so cloudflared gets a chance to drain
+// in-flight connections before waitWithGrace's deadline.
+func (m *Manager) RunHA(ctx context.Context, haConnections int) error {
+	defer close(m.done)
+
+	cmd := exec.CommandContext(ctx,
+		"cloudflared",
+		"--config", m.ConfigPath,
+		"--credentials-file", m.CredentialsPath,
+		"--ha-connections", strconv.Itoa(haConnections),
+		"tunnel", "run", m.TunnelID,
+	)
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to attach cloudflared stdout: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("failed to attach cloudflared stderr: %w", err)
+	}
+
+	m.mu.Lock()
+	m.cmd = cmd
+	m.mu.Unlock()
+
+	m.logger.Info().Int("haConnections", haConnections).Str("config", m.ConfigPath).Msg("starting cloudflared")
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start cloudflared: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); scanIntoLogger(stdout, m.logger, zerolog.InfoLevel) }()
+	go func() { defer wg.Done(); scanIntoLogger(stderr, m.logger, zerolog.WarnLevel) }()
+	wg.Wait()
+
+	if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("cloudflared exited: %w", err)
+	}
+	return ctx.Err()
+}
+
+// Wait blocks until Run/RunHA has returned.
+func (m *Manager) Wait() {
+	<-m.done
+}
+
+// scanIntoLogger re-emits cloudflared's stdout/stderr line by line into
+// zerolog instead of letting the subprocess bypass structured logging. It
+// only has tunnelID to attach: this one subprocess multiplexes every HA
+// connection for the tunnel, so there's no single hostname/originURL/
+// connIndex/protocol to tag a given line with. hostname, originURL and
+// protocol are attached at the route-setup log sites in main.go and
+// loadbalancer.go instead; connIndex is not logged anywhere.
+func scanIntoLogger(r io.Reader, logger zerolog.Logger, level zerolog.Level) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		logger.WithLevel(level).Str("source", "cloudflared").Msg(scanner.Text())
+	}
+}
@@ -0,0 +1,44 @@
+package tunnel
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/anik-ghosh-au7/cloudflare-tunnel/cliutil"
+)
+
+// tokenPayload is the decoded shape of a tunnel token: a base64-encoded JSON
+// blob of {"a": AccountTag, "t": TunnelID, "s": TunnelSecret}, the same
+// format `cloudflared tunnel token` prints and `--token` accepts.
+type tokenPayload struct {
+	AccountTag string `json:"a"`
+	TunnelID   string `json:"t"`
+	Secret     string `json:"s"`
+}
+
+// ParseToken decodes a tunnel token into Credentials, so a tunnel can be run
+// without `cloudflared tunnel login` or a stored credentials.json. It
+// returns a *cliutil.UsageError on any malformed input.
+func ParseToken(token string) (*Credentials, error) {
+	raw, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		raw, err = base64.RawStdEncoding.DecodeString(token)
+		if err != nil {
+			return nil, cliutil.NewUsageError("provided tunnel token is not valid: %v", err)
+		}
+	}
+
+	var payload tokenPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, cliutil.NewUsageError("provided tunnel token is not valid: %v", err)
+	}
+	if payload.AccountTag == "" || payload.TunnelID == "" || payload.Secret == "" {
+		return nil, cliutil.NewUsageError("provided tunnel token is not valid: missing account tag, tunnel id, or secret")
+	}
+
+	return &Credentials{
+		AccountTag:   payload.AccountTag,
+		TunnelID:     payload.TunnelID,
+		TunnelSecret: payload.Secret,
+	}, nil
+}